@@ -6,10 +6,16 @@ package webserver
 
 import (
 	"crypto/tls"
+	"errors"
 	"net"
+	"os"
+	"sync"
 	"sync/atomic"
+	"time"
 )
 
+var errNotATCPListener = errors.New("The underlying listener is not a *net.TCPListener.")
+
 // ErrListenerClosed is an error type that indicates that the listener has
 // intentionally been closed.
 type ErrListenerClosed struct {
@@ -20,29 +26,46 @@ type ErrListenerClosed struct {
 // handles HTTP and HTTPS requests, while also supporting graceful shutdowns.
 type WebServerListener struct {
 	net.Listener
-	listening int32
+	tlsConfig       *tls.Config
+	listening       int32
+	maxConns        chan struct{}
+	keepAliveSet    bool
+	keepAlivePeriod time.Duration
 }
 
 // Listen creates a WebServerListener. If tlsConfig is nil, an HTTP listener
 // is created. Otherwise, an HTTPS listener is created.
 func Listen(network, laddr string, tlsConfig *tls.Config) (*WebServerListener, error) {
-	var listener net.Listener
-	var err error
-
-	if tlsConfig != nil {
-		listener, err = tls.Listen(network, laddr, tlsConfig)
-	} else {
-		listener, err = net.Listen(network, laddr)
-	}
+	raw, err := net.Listen(network, laddr)
 	if err != nil {
 		return nil, err
 	}
 
-	wsl := &WebServerListener{
-		Listener:  listener,
+	return newWebServerListener(raw, tlsConfig), nil
+}
+
+// newWebServerListener wraps raw in a WebServerListener. Unlike tls.NewListener,
+// TLS is not applied at the listener level: Accept keeps raw's *net.TCPConn
+// around long enough to configure TCP keep-alives before handing it off to
+// tls.Server, so that SetKeepAlivePeriod still takes effect on an HTTPS
+// listener.
+func newWebServerListener(raw net.Listener, tlsConfig *tls.Config) *WebServerListener {
+	return &WebServerListener{
+		Listener:  raw,
+		tlsConfig: tlsConfig,
 		listening: 1,
 	}
-	return wsl, nil
+}
+
+// File returns a duplicate of the underlying listening socket's file
+// descriptor, for passing to a child process during a zero-downtime
+// restart. The caller is responsible for closing it.
+func (l *WebServerListener) File() (*os.File, error) {
+	tcpListener, ok := l.Listener.(*net.TCPListener)
+	if !ok {
+		return nil, errNotATCPListener
+	}
+	return tcpListener.File()
 }
 
 // Listening returns true when listening for connections, and false when not.
@@ -50,16 +73,75 @@ func (l *WebServerListener) Listening() bool {
 	return atomic.LoadInt32(&l.listening) != 0
 }
 
+// SetMaxConnections caps the number of concurrent connections Accept() will
+// hand out to n. Once n connections are outstanding, Accept() blocks until
+// one of them is closed. A value of 0 removes the cap.
+func (l *WebServerListener) SetMaxConnections(n int) {
+	if n <= 0 {
+		l.maxConns = nil
+		return
+	}
+	l.maxConns = make(chan struct{}, n)
+}
+
+// SetKeepAlivePeriod causes every accepted *net.TCPConn to have TCP
+// keep-alives enabled with the given period, so that idle connections whose
+// peer has gone away are eventually pruned by the OS rather than
+// accumulating forever.
+func (l *WebServerListener) SetKeepAlivePeriod(d time.Duration) {
+	l.keepAliveSet = true
+	l.keepAlivePeriod = d
+}
+
 // Accept implements the Accept() method of the net.Listener interface.
 func (l *WebServerListener) Accept() (net.Conn, error) {
+	if l.maxConns != nil {
+		l.maxConns <- struct{}{}
+	}
+
 	conn, err := l.Listener.Accept()
 	if err != nil {
+		if l.maxConns != nil {
+			<-l.maxConns
+		}
 		if !l.Listening() {
 			return nil, ErrListenerClosed{err}
 		}
+		return nil, err
+	}
+
+	if l.keepAliveSet {
+		if tcpConn, ok := conn.(*net.TCPConn); ok {
+			tcpConn.SetKeepAlive(true)
+			tcpConn.SetKeepAlivePeriod(l.keepAlivePeriod)
+		}
+	}
+
+	if l.tlsConfig != nil {
+		conn = tls.Server(conn, l.tlsConfig)
+	}
+
+	if l.maxConns != nil {
+		conn = &limitedConn{Conn: conn, slots: l.maxConns}
 	}
 
-	return conn, err
+	return conn, nil
+}
+
+// limitedConn wraps a net.Conn accepted by a connection-limited listener,
+// releasing its semaphore slot exactly once when the connection is closed.
+type limitedConn struct {
+	net.Conn
+	slots     chan struct{}
+	closeOnce sync.Once
+}
+
+// Close implements the Close() method of the net.Conn interface.
+func (c *limitedConn) Close() error {
+	defer c.closeOnce.Do(func() {
+		<-c.slots
+	})
+	return c.Conn.Close()
 }
 
 // Close implements the Close() method of the net.Listener interface.
@@ -0,0 +1,178 @@
+// Copyright 2014 Ryan Rogers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package webserver
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+const mtlsListenAddr = "127.0.0.1:44381"
+
+// genTestCA generates a self-signed CA certificate, for signing client
+// certificates used to exercise mTLS.
+func genTestCA(t *testing.T) (*x509.Certificate, *ecdsa.PrivateKey, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "mTLS Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create CA certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("Failed to parse CA certificate: %v", err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+
+	return cert, key, certPEM
+}
+
+// genTestClientCertPEM generates a client certificate with the given
+// subject common name, signed by the given CA.
+func genTestClientCertPEM(t *testing.T, ca *x509.Certificate, caKey *ecdsa.PrivateKey, cn string) (certPEM, keyPEM []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("Failed to create client certificate: %v", err)
+	}
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("Failed to marshal client private key: %v", err)
+	}
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+
+	return certPEM, keyPEM
+}
+
+func TestMTLS(t *testing.T) {
+	server := New()
+
+	serverCertPEM, serverKeyPEM := genTestCertPEM(t, []string{"127.0.0.1"}, []net.IP{net.ParseIP("127.0.0.1")})
+	if err := server.AddTLSCertificate(serverCertPEM, serverKeyPEM); err != nil {
+		t.Fatalf("Expected AddTLSCertificate to succeed, received error '%v'.", err)
+	}
+
+	caCert, caKey, caCertPEM := genTestCA(t)
+	if err := server.SetClientCAs(caCertPEM); err != nil {
+		t.Fatalf("Expected SetClientCAs to succeed, received error '%v'.", err)
+	}
+	server.RequireClientCert(tls.RequireAndVerifyClientCert)
+
+	mux := http.NewServeMux()
+	mux.Handle(simpleRoute, server.VerifiedPeerHandler(http.HandlerFunc(simpleRouteHandler), []string{"allowed-client"}))
+	server.Server.Handler = mux
+
+	if err := server.Listen(mtlsListenAddr); err != nil {
+		t.Fatalf("Expected Listen to succeed, received error '%v'.", err)
+	}
+	defer server.Shutdown()
+
+	if err := server.Serve(errChan); err != nil {
+		t.Fatalf("Expected Serve to succeed, received error '%v'.", err)
+	}
+
+	url := "https://" + mtlsListenAddr + simpleRoute
+
+	// No client certificate: the TLS handshake itself should fail.
+	noCertClient := &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		},
+	}
+	if _, err := noCertClient.Get(url); err == nil {
+		t.Fatal("Expected the request with no client certificate to fail.")
+	}
+
+	// A client certificate signed by the trusted CA, with an allowed CN.
+	allowedCertPEM, allowedKeyPEM := genTestClientCertPEM(t, caCert, caKey, "allowed-client")
+	allowedCert, err := tls.X509KeyPair(allowedCertPEM, allowedKeyPEM)
+	if err != nil {
+		t.Fatalf("Failed to load allowed client certificate: %v", err)
+	}
+	allowedClient := &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{
+				InsecureSkipVerify: true,
+				Certificates:       []tls.Certificate{allowedCert},
+			},
+		},
+	}
+	resp, err := allowedClient.Get(url)
+	if err != nil {
+		t.Fatalf("Expected the allowed client's request to succeed, received error '%v'.", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status %d, received %d.", http.StatusOK, resp.StatusCode)
+	}
+
+	// A client certificate signed by the trusted CA, but with a CN that
+	// isn't in the handler's allow-list.
+	deniedCertPEM, deniedKeyPEM := genTestClientCertPEM(t, caCert, caKey, "other-client")
+	deniedCert, err := tls.X509KeyPair(deniedCertPEM, deniedKeyPEM)
+	if err != nil {
+		t.Fatalf("Failed to load denied client certificate: %v", err)
+	}
+	deniedClient := &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{
+				InsecureSkipVerify: true,
+				Certificates:       []tls.Certificate{deniedCert},
+			},
+		},
+	}
+	resp, err = deniedClient.Get(url)
+	if err != nil {
+		t.Fatalf("Expected the denied client's request to succeed at the transport level, received error '%v'.", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("Expected status %d, received %d.", http.StatusForbidden, resp.StatusCode)
+	}
+
+	server.Shutdown()
+	err = <-errChan
+	if _, graceful := err.(ErrGracefulShutdown); !graceful {
+		t.Fatalf("Expected error %T, received error %T.", ErrGracefulShutdown{}, err)
+	}
+}
@@ -0,0 +1,16 @@
+// Copyright 2014 Ryan Rogers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package webserver
+
+import "errors"
+
+// ErrRestartUnsupported is returned by Restart on platforms that don't
+// support passing an open socket to a child process.
+var ErrRestartUnsupported = errors.New("Restart is not supported on this platform.")
+
+// listenerFDEnvVar is the environment variable a restarted child process
+// looks at to find the file descriptor of the listening socket inherited
+// from its parent.
+const listenerFDEnvVar = "WEBSERVER_LISTENER_FD"
@@ -0,0 +1,110 @@
+// Copyright 2014 Ryan Rogers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !windows
+// +build !windows
+
+package webserver
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"syscall"
+)
+
+// ListenInherited behaves like Listen, except that if the environment
+// variable named by listenerFDEnvVar is set, it reconstructs the listener
+// from that inherited file descriptor instead of creating a fresh one. This
+// is how a child process started by Restart picks up the listening socket
+// its parent was already accepting connections on.
+func ListenInherited(addr string, tlsConfig *tls.Config) (*WebServerListener, error) {
+	fdStr := os.Getenv(listenerFDEnvVar)
+	if fdStr == "" {
+		return Listen("tcp", addr, tlsConfig)
+	}
+
+	fd, err := strconv.Atoi(fdStr)
+	if err != nil {
+		return nil, fmt.Errorf("webserver: invalid %s %q: %v", listenerFDEnvVar, fdStr, err)
+	}
+
+	file := os.NewFile(uintptr(fd), "webserver-listener")
+	raw, err := net.FileListener(file)
+	file.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return newWebServerListener(raw, tlsConfig), nil
+}
+
+// Restart performs a zero-downtime restart: it re-execs the current binary,
+// passing the open listening socket to the child via ExtraFiles so that it
+// can immediately begin accepting connections on the same address, and then
+// puts this server into a graceful shutdown. The child finds the inherited
+// socket through ListenInherited.
+func (s *WebServer) Restart() error {
+	if !s.listener.Listening() {
+		return errListenerNotYetCreated
+	}
+
+	listenerFile, err := s.listener.File()
+	if err != nil {
+		return err
+	}
+	defer listenerFile.Close()
+
+	execPath, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.Command(execPath)
+	cmd.Args = os.Args
+	cmd.Env = append(os.Environ(), fmt.Sprintf("%s=3", listenerFDEnvVar))
+	cmd.ExtraFiles = []*os.File{listenerFile}
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	s.Shutdown()
+	return nil
+}
+
+// RestartOnSIGUSR2 installs a signal handler that calls Restart whenever the
+// process receives SIGUSR2, and returns a channel that receives the result
+// of each such attempt. The channel is buffered, but if the caller isn't
+// keeping up, the oldest unread result is dropped in favor of the newest
+// one rather than blocking and missing subsequent SIGUSR2s.
+func (s *WebServer) RestartOnSIGUSR2() <-chan error {
+	restartErrs := make(chan error, 1)
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGUSR2)
+
+	go func() {
+		for range sigChan {
+			err := s.Restart()
+			select {
+			case restartErrs <- err:
+			default:
+				select {
+				case <-restartErrs:
+				default:
+				}
+				restartErrs <- err
+			}
+		}
+	}()
+
+	return restartErrs
+}
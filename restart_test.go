@@ -0,0 +1,22 @@
+// Copyright 2014 Ryan Rogers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package webserver
+
+import "testing"
+
+func TestListenerFile(t *testing.T) {
+	server := newServer()
+
+	if err := server.Listen(listenAddr); err != nil {
+		t.Fatalf("Expected Listen to succeed, received error '%v'.", err)
+	}
+	defer server.Shutdown()
+
+	file, err := server.listener.File()
+	if err != nil {
+		t.Fatalf("Expected File to succeed, received error '%v'.", err)
+	}
+	file.Close()
+}
@@ -0,0 +1,33 @@
+// Copyright 2014 Ryan Rogers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build windows
+// +build windows
+
+package webserver
+
+import "crypto/tls"
+
+// ListenInherited behaves like Listen. Windows has no equivalent of passing
+// an open socket to a child process via ExtraFiles, so there's never an
+// inherited listener to reconstruct.
+func ListenInherited(addr string, tlsConfig *tls.Config) (*WebServerListener, error) {
+	return Listen("tcp", addr, tlsConfig)
+}
+
+// Restart always returns ErrRestartUnsupported; zero-downtime restart via
+// listener-fd inheritance requires POSIX facilities that Windows doesn't
+// provide.
+func (s *WebServer) Restart() error {
+	return ErrRestartUnsupported
+}
+
+// RestartOnSIGUSR2 returns a closed channel carrying a single
+// ErrRestartUnsupported, since Windows has no SIGUSR2 to listen for.
+func (s *WebServer) RestartOnSIGUSR2() <-chan error {
+	restartErrs := make(chan error, 1)
+	restartErrs <- ErrRestartUnsupported
+	close(restartErrs)
+	return restartErrs
+}
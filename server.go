@@ -6,11 +6,15 @@
 package webserver
 
 import (
+	"context"
 	"crypto/tls"
 	"errors"
 	"net"
 	"net/http"
 	"sync"
+	"time"
+
+	"golang.org/x/net/http2"
 )
 
 var (
@@ -24,13 +28,32 @@ type ErrGracefulShutdown struct {
 	error
 }
 
+// ErrShutdownTimeout is an error type that indicates that a bounded
+// shutdown's deadline elapsed before all in-flight requests and tracked
+// goroutines finished on their own, and that any connections still being
+// tracked at that point were forcibly closed as a result.
+type ErrShutdownTimeout struct {
+	error
+}
+
 // WebServer is a simple HTTP/HTTPS server.
 type WebServer struct {
-	Server    *http.Server
-	ConnState func(net.Conn, http.ConnState)
-	listener  *WebServerListener
-	wg        sync.WaitGroup
-	idleConns map[net.Conn]struct{}
+	Server         *http.Server
+	ConnState      func(net.Conn, http.ConnState)
+	listener       *WebServerListener
+	wg             sync.WaitGroup
+	connsMu        sync.Mutex
+	conns          map[net.Conn]struct{}
+	idleConns      map[net.Conn]struct{}
+	activatedConns map[net.Conn]struct{}
+	shutdownForced chan struct{}
+
+	certMu      sync.Mutex
+	certsByName map[string]*tls.Certificate
+	certOrder   []*tls.Certificate
+
+	http2Opts    *http2.Server
+	http2Enabled bool
 }
 
 // New creates a new WebServer.
@@ -39,45 +62,12 @@ func New() *WebServer {
 		Server: &http.Server{
 			TLSConfig: nil,
 		},
-		listener:  &WebServerListener{},
-		idleConns: map[net.Conn]struct{}{},
-	}
-}
-
-// AddTLSCertificate reads the certificate and private key from the provided
-// PEM blocks, and adds the certificate to the list of certificates that the
-// server can use.
-func (s *WebServer) AddTLSCertificate(certPEMBlock, keyPEMBlock []byte) error {
-	cert, err := tls.X509KeyPair(certPEMBlock, keyPEMBlock)
-	if err != nil {
-		return err
-	}
-
-	s.addTLSCert(cert)
-	return nil
-}
-
-// AddTLSCertificateFromFile reads the certificate and private key from the
-// provided file paths, and adds the certificate to the list of certificates
-// that the server can use.
-func (s *WebServer) AddTLSCertificateFromFile(certFile, keyFile string) error {
-	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
-	if err != nil {
-		return err
+		listener:       &WebServerListener{},
+		conns:          map[net.Conn]struct{}{},
+		idleConns:      map[net.Conn]struct{}{},
+		activatedConns: map[net.Conn]struct{}{},
+		certsByName:    map[string]*tls.Certificate{},
 	}
-
-	s.addTLSCert(cert)
-	return nil
-}
-
-// addTLSCert adds the provided certificate to the list of certificates that
-// the server can use.
-func (s *WebServer) addTLSCert(cert tls.Certificate) {
-	if s.Server.TLSConfig == nil {
-		s.Server.TLSConfig = s.DefaultTLSConfiguration()
-	}
-	s.Server.TLSConfig.Certificates = append(s.Server.TLSConfig.Certificates, cert)
-	s.Server.TLSConfig.BuildNameToCertificate()
 }
 
 // DefaultTLSConfiguration returns a base TLS configuration that can then be
@@ -85,25 +75,24 @@ func (s *WebServer) addTLSCert(cert tls.Certificate) {
 func (s *WebServer) DefaultTLSConfiguration() *tls.Config {
 	return &tls.Config{
 		Certificates: []tls.Certificate{},
-		NextProtos:   []string{"http/1.1"},
+		// h2 is listed first so that it's negotiated via ALPN whenever the
+		// client supports it; http/1.1 remains available as a fallback.
+		NextProtos: []string{"h2", "http/1.1"},
+		// HTTP/2 requires TLS 1.2 or later.
+		MinVersion: tls.VersionTLS12,
 		// Reasoning behind the cipher suite ordering:
 		//
 		// - The first priority is forward secrecy, so we will prefer to use
 		//   ECDHE over RSA for the key exchange.
 		// - Of the available ciphers, only AES-GCM is free of known attacks,
 		//   so we will want to use that if possible.
-		// - Go's CBC-mode ciphers are vulnerable to timing attacks. The only
-		//   other alternative would be RC4, which should be considered broken
-		//   at this point.
-		// - As explained above, 3DES is a better last resort than RC4.
+		// - RFC 7540 Section 9.2.2 forbids every other cipher suite that was
+		//   previously listed here (the CBC-mode and 3DES suites) from being
+		//   used over an HTTP/2 connection, so they've been dropped entirely
+		//   rather than kept around as a fallback.
 		CipherSuites: []uint16{
 			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
 			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
-			tls.TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA,
-			tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA,
-			tls.TLS_ECDHE_RSA_WITH_3DES_EDE_CBC_SHA,
-			tls.TLS_RSA_WITH_AES_128_CBC_SHA,
-			tls.TLS_RSA_WITH_3DES_EDE_CBC_SHA,
 		},
 		PreferServerCipherSuites: true,
 		SessionTicketsDisabled:   false,
@@ -112,26 +101,104 @@ func (s *WebServer) DefaultTLSConfiguration() *tls.Config {
 
 // Listen begins listening on the given address.
 func (s *WebServer) Listen(addr string) error {
+	return s.listenWith(addr, func() (*WebServerListener, error) {
+		return Listen("tcp", addr, s.listenerTLSConfig())
+	})
+}
+
+// ListenInherited behaves like Listen, except that if this process was
+// started by a call to Restart, it resumes serving on the listening socket
+// inherited from its parent instead of creating a fresh one.
+func (s *WebServer) ListenInherited(addr string) error {
+	return s.listenWith(addr, func() (*WebServerListener, error) {
+		return ListenInherited(addr, s.listenerTLSConfig())
+	})
+}
+
+// listenerTLSConfig returns the TLS configuration the listener should use,
+// or nil if no certificate has actually been registered. s.Server.TLSConfig
+// alone isn't a reliable signal: (*http.Server).Serve sets it to a non-nil
+// placeholder (for its own h2 auto-configuration) as a side effect of its
+// first call, even for a plain HTTP server that never added a certificate,
+// which would otherwise make a subsequent Listen on the same WebServer
+// start speaking TLS with no certificate to offer.
+func (s *WebServer) listenerTLSConfig() *tls.Config {
+	cfg := s.Server.TLSConfig
+	if cfg == nil || (len(cfg.Certificates) == 0 && cfg.GetCertificate == nil) {
+		return nil
+	}
+	return cfg
+}
+
+// listenWith is the shared implementation behind Listen and ListenInherited:
+// it invokes listen to obtain a listener, then carries over any
+// connection-limiting options that were configured on the placeholder
+// listener before either was called.
+func (s *WebServer) listenWith(addr string, listen func() (*WebServerListener, error)) error {
 	if s.listener.Listening() {
 		return errListenerAlreadyCreated
 	}
 
-	listener, err := Listen("tcp", addr, s.Server.TLSConfig)
+	listener, err := listen()
 	if err != nil {
 		return err
 	}
 
+	listener.maxConns = s.listener.maxConns
+	listener.keepAliveSet = s.listener.keepAliveSet
+	listener.keepAlivePeriod = s.listener.keepAlivePeriod
+
 	s.listener = listener
 	s.Server.Addr = addr
 	return nil
 }
 
+// SetMaxConnections caps the number of concurrent connections the server
+// will accept to n, shedding load rather than risking fd exhaustion. A
+// value of 0 removes the cap. It may be called either before or after
+// Listen.
+func (s *WebServer) SetMaxConnections(n int) {
+	s.listener.SetMaxConnections(n)
+}
+
+// SetKeepAlivePeriod enables TCP keep-alives with the given period on every
+// connection the server accepts, so that idle connections whose peer has
+// disappeared are eventually pruned by the OS instead of accumulating. It
+// may be called either before or after Listen.
+func (s *WebServer) SetKeepAlivePeriod(d time.Duration) {
+	s.listener.SetKeepAlivePeriod(d)
+}
+
+// EnableHTTP2 configures the server to negotiate HTTP/2 over TLS via ALPN,
+// using opts to tune stream and frame limits. It's called automatically
+// with a zero-value *http2.Server the first time a TLS certificate is
+// added, so callers only need this if they want non-default http2.Server
+// settings; it must then be called before the certificate is added. A nil
+// opts is equivalent to new(http2.Server).
+func (s *WebServer) EnableHTTP2(opts *http2.Server) error {
+	if opts == nil {
+		opts = &http2.Server{}
+	}
+	s.http2Opts = opts
+
+	if s.Server.TLSConfig == nil {
+		return nil
+	}
+	if err := http2.ConfigureServer(s.Server, opts); err != nil {
+		return err
+	}
+	s.http2Enabled = true
+	return nil
+}
+
 // Serve begins serving connections.
 func (s *WebServer) Serve(errChan chan<- error) error {
 	if !s.listener.Listening() {
 		return errListenerNotYetCreated
 	}
 
+	s.shutdownForced = make(chan struct{})
+
 	servingChan := make(chan struct{})
 	go func() {
 		s.Server.ConnState = s.connStateTracker
@@ -139,7 +206,20 @@ func (s *WebServer) Serve(errChan chan<- error) error {
 		err := s.Server.Serve(s.listener)
 		// Serve() can't return an err of nil, so no need to check for it.
 		if _, graceful := err.(ErrListenerClosed); graceful {
-			s.wg.Wait()
+			wgDone := make(chan struct{})
+			go func() {
+				s.wg.Wait()
+				close(wgDone)
+			}()
+			// A bounded shutdown that gave up and force-closed whatever it
+			// was still tracking can leave the WaitGroup permanently above
+			// zero, e.g. a caller's RoutineStarted that never reached a
+			// matching RoutineFinished. shutdownForced lets ShutdownContext
+			// unstick us in that case instead of waiting forever.
+			select {
+			case <-wgDone:
+			case <-s.shutdownForced:
+			}
 			err = ErrGracefulShutdown{err}
 		}
 		errChan <- err
@@ -149,7 +229,10 @@ func (s *WebServer) Serve(errChan chan<- error) error {
 	return nil
 }
 
-// Shutdown stops serving connections.
+// Shutdown stops serving connections. It closes the listener immediately,
+// but otherwise returns without waiting for in-flight requests or tracked
+// goroutines to finish; see ShutdownWithTimeout and ShutdownContext for a
+// bounded alternative.
 func (s *WebServer) Shutdown() {
 	if !s.listener.Listening() {
 		return
@@ -159,6 +242,61 @@ func (s *WebServer) Shutdown() {
 	s.listener.Close()
 }
 
+// ShutdownWithTimeout stops accepting new connections and waits up to d for
+// in-flight requests and any goroutines started via RoutineStarted to
+// finish. If the deadline expires first, every connection still being
+// tracked is forcibly closed, the closed connections are returned, and err
+// is an ErrShutdownTimeout.
+func (s *WebServer) ShutdownWithTimeout(d time.Duration) ([]net.Conn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), d)
+	defer cancel()
+	return s.ShutdownContext(ctx)
+}
+
+// ShutdownContext behaves like ShutdownWithTimeout, except that the bound on
+// how long to wait is provided by ctx rather than a fixed duration.
+func (s *WebServer) ShutdownContext(ctx context.Context) ([]net.Conn, error) {
+	if !s.listener.Listening() {
+		return nil, nil
+	}
+
+	s.Server.SetKeepAlivesEnabled(false)
+	s.listener.Close()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil, nil
+	case <-ctx.Done():
+		closed := s.closeTrackedConns()
+		// Forcibly closing the tracked conns can't undo a RoutineStarted
+		// that never called RoutineFinished, so the WaitGroup may never
+		// reach zero on its own. Tell Serve's goroutine to stop waiting on
+		// it so errChan still fires.
+		close(s.shutdownForced)
+		return closed, ErrShutdownTimeout{ctx.Err()}
+	}
+}
+
+// closeTrackedConns forcibly closes every connection still being tracked by
+// connStateTracker, and returns the ones it closed.
+func (s *WebServer) closeTrackedConns() []net.Conn {
+	s.connsMu.Lock()
+	defer s.connsMu.Unlock()
+
+	closed := make([]net.Conn, 0, len(s.conns))
+	for c := range s.conns {
+		c.Close()
+		closed = append(closed, c)
+	}
+	return closed
+}
+
 // RoutineStarted informs the server that a request handler has started a
 // goroutine, and that the server should wait for it to be finished before
 // completing a graceful shutdown.
@@ -191,20 +329,59 @@ func (s *WebServer) connStateTracker(c net.Conn, state http.ConnState) {
 	// This also means that connections which have entered the idle state
 	// should not decrease the WaitGroup counter when it enters the closed
 	// state.
+	//
+	// Separately, and independent of the above, every connection from the
+	// moment it's dialed until it's closed or hijacked is tracked in conns,
+	// so that a bounded shutdown can forcibly close whatever is still
+	// outstanding once its deadline expires.
+	//
+	// HTTP/2 connections are not hijacked: net/http reports New, skips the
+	// hook for its own Active, and reports Closed once the session ends. In
+	// between, the http2 server reports Active and then Idle as soon as the
+	// client preface is read, and Active/Idle again as streams open and
+	// drain, so they are accounted for like keep-alive HTTP/1.x connections.
+	//
+	// A connection can also go straight from New to Closed without ever
+	// reaching Active, most commonly when a TLS handshake fails (e.g. a
+	// client certificate rejected under RequireClientCert). Only New's
+	// Add(1) needs undoing in that case, so activatedConns records whether
+	// Active was ever entered.
 	switch state {
-	case http.StateNew, http.StateActive:
+	case http.StateNew:
+		s.connsMu.Lock()
+		s.conns[c] = struct{}{}
+		s.connsMu.Unlock()
+		s.wg.Add(1)
+	case http.StateActive:
+		s.connsMu.Lock()
+		s.activatedConns[c] = struct{}{}
+		s.connsMu.Unlock()
 		s.wg.Add(1)
 	case http.StateIdle:
-		if _, idle := s.idleConns[c]; !idle {
+		s.connsMu.Lock()
+		_, wasIdle := s.idleConns[c]
+		s.idleConns[c] = struct{}{}
+		s.connsMu.Unlock()
+		if !wasIdle {
 			s.wg.Done()
 		}
-		s.idleConns[c] = struct{}{}
 		s.wg.Done()
 	case http.StateHijacked, http.StateClosed:
-		if _, idle := s.idleConns[c]; idle {
-			delete(s.idleConns, c)
-		} else {
+		s.connsMu.Lock()
+		delete(s.conns, c)
+		_, wasIdle := s.idleConns[c]
+		_, wasActivated := s.activatedConns[c]
+		delete(s.idleConns, c)
+		delete(s.activatedConns, c)
+		s.connsMu.Unlock()
+
+		switch {
+		case wasIdle:
+			// Already fully accounted for when it first went idle.
+		case wasActivated:
 			s.wg.Add(-2)
+		default:
+			s.wg.Add(-1)
 		}
 	}
 
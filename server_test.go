@@ -9,9 +9,12 @@ import (
 	"crypto/x509"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"testing"
 	"time"
+
+	"golang.org/x/net/http2"
 )
 
 // Server configuration.
@@ -183,6 +186,137 @@ func TestGracefulShutdown(t *testing.T) {
 	}
 }
 
+func TestShutdownWithTimeout(t *testing.T) {
+	server := newServer()
+
+	if err := server.Listen(listenAddr); err != nil {
+		t.Fatalf("Expected Listen to succeed, received error '%v'.", err)
+	}
+	defer server.Shutdown()
+
+	if err := server.Serve(errChan); err != nil {
+		t.Fatalf("Expected Serve to succeed, received error '%v'.", err)
+	}
+
+	reqParams := requestParams{
+		tls:           false,
+		addr:          listenAddr,
+		route:         longRunningRoute,
+		expectSuccess: false,
+	}
+
+	// Start a long running request, and wait for it to start, without ever
+	// calling RoutineFinished(), so it looks wedged to the server.
+	server.RoutineStarted()
+	go func() {
+		testRequest(reqParams)
+	}()
+
+	<-longRunningChan
+	closed, err := server.ShutdownWithTimeout(100 * time.Millisecond)
+	if _, timedOut := err.(ErrShutdownTimeout); !timedOut {
+		t.Fatalf("Expected error %T, received error %T.", ErrShutdownTimeout{}, err)
+	}
+	if len(closed) == 0 {
+		t.Fatal("Expected at least one connection to be forcibly closed.")
+	}
+
+	<-errChan
+}
+
+func TestMaxConnections(t *testing.T) {
+	server := newServer()
+	server.SetMaxConnections(1)
+
+	if err := server.Listen(listenAddr); err != nil {
+		t.Fatalf("Expected Listen to succeed, received error '%v'.", err)
+	}
+	defer server.Shutdown()
+
+	if err := server.Serve(errChan); err != nil {
+		t.Fatalf("Expected Serve to succeed, received error '%v'.", err)
+	}
+
+	reqParams := requestParams{
+		tls:           false,
+		addr:          listenAddr,
+		route:         longRunningRoute,
+		expectSuccess: true,
+	}
+
+	// Occupy the single available connection slot.
+	server.RoutineStarted()
+	go func() {
+		defer server.RoutineFinished()
+		if err := testRequest(reqParams); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	<-longRunningChan
+
+	// A second, concurrent connection should queue behind the semaphore
+	// rather than being accepted right away.
+	done := make(chan struct{})
+	go func() {
+		reqParams.route = simpleRoute
+		testRequest(reqParams)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("Expected second connection to be blocked while the first is outstanding.")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	<-done
+	server.Shutdown()
+	<-errChan
+}
+
+func TestHTTP2(t *testing.T) {
+	server := newServer()
+
+	certPEM, keyPEM := genTestCertPEM(t, []string{"127.0.0.1"}, []net.IP{net.ParseIP("127.0.0.1")})
+	if err := server.AddTLSCertificate(certPEM, keyPEM); err != nil {
+		t.Fatalf("Expected AddTLSCertificate to succeed, received error '%v'.", err)
+	}
+
+	if err := server.Listen(listenAddr); err != nil {
+		t.Fatalf("Expected Listen to succeed, received error '%v'.", err)
+	}
+	defer server.Shutdown()
+
+	if err := server.Serve(errChan); err != nil {
+		t.Fatalf("Expected Serve to succeed, received error '%v'.", err)
+	}
+
+	h2Client := &http.Client{
+		Transport: &http2.Transport{
+			// The certificate generated above is self-signed and not
+			// chained to any trusted CA.
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		},
+	}
+
+	resp, err := h2Client.Get("https://" + listenAddr + simpleRoute)
+	if err != nil {
+		t.Fatalf("Expected request to succeed, received error '%v'.", err)
+	}
+	resp.Body.Close()
+	if resp.ProtoMajor != 2 {
+		t.Fatalf("Expected an HTTP/2 response, received protocol '%s'.", resp.Proto)
+	}
+
+	server.Shutdown()
+
+	err = <-errChan
+	if _, graceful := err.(ErrGracefulShutdown); !graceful {
+		t.Fatalf("Expected error %T, received error %T.", ErrGracefulShutdown{}, err)
+	}
+}
+
 func simpleRouteHandler(w http.ResponseWriter, req *http.Request) {
 	fmt.Fprintln(w, "Success")
 }
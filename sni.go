@@ -0,0 +1,191 @@
+// Copyright 2014 Ryan Rogers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package webserver
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"strings"
+)
+
+var (
+	errNoSNIHostnames      = errors.New("At least one hostname must be provided.")
+	errCertificateNotFound = errors.New("No certificate is bound to the given hostname.")
+	errNoCertificatesAdded = errors.New("No certificates have been added to the server.")
+)
+
+// AddTLSCertificate reads the certificate and private key from the provided
+// PEM blocks, and adds the certificate to the list of certificates that the
+// server can use. The certificate is bound to every DNS name and IP address
+// in its leaf's Subject Alternative Names.
+func (s *WebServer) AddTLSCertificate(certPEMBlock, keyPEMBlock []byte) error {
+	cert, err := tls.X509KeyPair(certPEMBlock, keyPEMBlock)
+	if err != nil {
+		return err
+	}
+
+	return s.addTLSCert(cert)
+}
+
+// AddTLSCertificateFromFile reads the certificate and private key from the
+// provided file paths, and adds the certificate to the list of certificates
+// that the server can use. The certificate is bound to every DNS name and IP
+// address in its leaf's Subject Alternative Names.
+func (s *WebServer) AddTLSCertificateFromFile(certFile, keyFile string) error {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return err
+	}
+
+	return s.addTLSCert(cert)
+}
+
+// AddSNICertificate binds cert to the given hostnames, independent of
+// whatever DNS names or IP addresses are present in the certificate's own
+// Subject Alternative Names. This is useful when a certificate should be
+// served for a hostname it wasn't issued for, or to override the SAN-derived
+// binding of a certificate added earlier.
+func (s *WebServer) AddSNICertificate(hostnames []string, cert tls.Certificate) error {
+	if len(hostnames) == 0 {
+		return errNoSNIHostnames
+	}
+	return s.registerCert(cert, hostnames, nil)
+}
+
+// RemoveTLSCertificate removes the certificate bound to hostname. If no
+// other hostname remains bound to that certificate, it's also removed from
+// the server's TLS configuration entirely.
+func (s *WebServer) RemoveTLSCertificate(hostname string) error {
+	name := strings.ToLower(hostname)
+
+	s.certMu.Lock()
+	defer s.certMu.Unlock()
+
+	cert, ok := s.certsByName[name]
+	if !ok {
+		return errCertificateNotFound
+	}
+	delete(s.certsByName, name)
+
+	for _, other := range s.certsByName {
+		if other == cert {
+			// Another hostname still references this certificate.
+			return nil
+		}
+	}
+
+	for i, c := range s.certOrder {
+		if c == cert {
+			s.certOrder = append(s.certOrder[:i], s.certOrder[i+1:]...)
+			break
+		}
+	}
+	if s.Server.TLSConfig != nil {
+		certs := s.Server.TLSConfig.Certificates[:0]
+		for _, c := range s.Server.TLSConfig.Certificates {
+			if !sameCertificate(c, *cert) {
+				certs = append(certs, c)
+			}
+		}
+		s.Server.TLSConfig.Certificates = certs
+	}
+	return nil
+}
+
+// addTLSCert parses cert's leaf and binds it to every DNS name and IP
+// address found in its Subject Alternative Names.
+func (s *WebServer) addTLSCert(cert tls.Certificate) error {
+	leaf := cert.Leaf
+	if leaf == nil {
+		var err error
+		leaf, err = x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			return err
+		}
+	}
+
+	hostnames := make([]string, 0, len(leaf.DNSNames))
+	hostnames = append(hostnames, leaf.DNSNames...)
+
+	return s.registerCert(cert, hostnames, leaf.IPAddresses)
+}
+
+// registerCert adds cert to the server's TLS configuration, and binds it to
+// the given hostnames (which may include wildcard entries such as
+// "*.example.com") and IP addresses.
+func (s *WebServer) registerCert(cert tls.Certificate, hostnames []string, ips []net.IP) error {
+	if s.Server.TLSConfig == nil {
+		s.Server.TLSConfig = s.DefaultTLSConfiguration()
+	}
+
+	certPtr := &cert
+
+	s.certMu.Lock()
+	for _, hostname := range hostnames {
+		s.certsByName[strings.ToLower(hostname)] = certPtr
+	}
+	for _, ip := range ips {
+		s.certsByName[ip.String()] = certPtr
+	}
+	s.certOrder = append(s.certOrder, certPtr)
+	s.certMu.Unlock()
+
+	s.Server.TLSConfig.Certificates = append(s.Server.TLSConfig.Certificates, cert)
+	s.Server.TLSConfig.GetCertificate = s.getCertificateForClientHello
+
+	if !s.http2Enabled {
+		if err := s.EnableHTTP2(s.http2Opts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getCertificateForClientHello resolves a ClientHelloInfo's requested server
+// name against the certificates bound via addTLSCert/AddSNICertificate. It
+// tries, in order: an exact hostname or IP match, a single-label wildcard
+// match on the leftmost label, and finally falls back to the first
+// certificate added.
+func (s *WebServer) getCertificateForClientHello(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	s.certMu.Lock()
+	defer s.certMu.Unlock()
+
+	name := strings.ToLower(hello.ServerName)
+
+	if cert, ok := s.certsByName[name]; ok {
+		return cert, nil
+	}
+
+	if idx := strings.IndexByte(name, '.'); idx != -1 {
+		wildcard := "*" + name[idx:]
+		if cert, ok := s.certsByName[wildcard]; ok {
+			return cert, nil
+		}
+	}
+
+	if len(s.certOrder) > 0 {
+		return s.certOrder[0], nil
+	}
+	return nil, errNoCertificatesAdded
+}
+
+// sameCertificate reports whether two certificates wrap the same leaf DER
+// bytes, to support matching by value rather than by pointer identity.
+func sameCertificate(a, b tls.Certificate) bool {
+	if len(a.Certificate) == 0 || len(b.Certificate) == 0 {
+		return false
+	}
+	if len(a.Certificate[0]) != len(b.Certificate[0]) {
+		return false
+	}
+	for i := range a.Certificate[0] {
+		if a.Certificate[0][i] != b.Certificate[0][i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,140 @@
+// Copyright 2014 Ryan Rogers. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package webserver
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+// genTestCertPEM generates a self-signed certificate bound to the given DNS
+// names and IP addresses, returning PEM-encoded cert and key blocks. This
+// lets SNI matching be exercised without depending on fixture files on disk.
+func genTestCertPEM(t *testing.T, dnsNames []string, ips []net.IP) (certPEM, keyPEM []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "sni-test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		DNSNames:     dnsNames,
+		IPAddresses:  ips,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("Failed to marshal private key: %v", err)
+	}
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+
+	return certPEM, keyPEM
+}
+
+func TestSNICertificateMatching(t *testing.T) {
+	server := New()
+
+	exactPEM, exactKeyPEM := genTestCertPEM(t, []string{"a.example.com"}, nil)
+	if err := server.AddTLSCertificate(exactPEM, exactKeyPEM); err != nil {
+		t.Fatalf("Expected AddTLSCertificate to succeed, received error '%v'.", err)
+	}
+
+	wildcardPEM, wildcardKeyPEM := genTestCertPEM(t, []string{"*.b.example.com"}, nil)
+	if err := server.AddTLSCertificate(wildcardPEM, wildcardKeyPEM); err != nil {
+		t.Fatalf("Expected AddTLSCertificate to succeed, received error '%v'.", err)
+	}
+
+	ipPEM, ipKeyPEM := genTestCertPEM(t, nil, []net.IP{net.ParseIP("127.0.0.1")})
+	if err := server.AddTLSCertificate(ipPEM, ipKeyPEM); err != nil {
+		t.Fatalf("Expected AddTLSCertificate to succeed, received error '%v'.", err)
+	}
+
+	cases := []struct {
+		serverName string
+		want       string
+	}{
+		{"a.example.com", "a.example.com"},
+		{"foo.b.example.com", "*.b.example.com"},
+		{"127.0.0.1", "127.0.0.1"},
+		{"unknown.example.com", "a.example.com"}, // falls back to first cert added
+	}
+	for _, c := range cases {
+		cert, err := server.getCertificateForClientHello(&tls.ClientHelloInfo{ServerName: c.serverName})
+		if err != nil {
+			t.Fatalf("ServerName %q: expected a certificate, received error '%v'.", c.serverName, err)
+		}
+		if want := server.certsByName[c.want]; cert != want {
+			t.Fatalf("ServerName %q: got a different certificate than expected.", c.serverName)
+		}
+	}
+}
+
+func TestAddSNICertificateOverridesSANs(t *testing.T) {
+	server := New()
+
+	certPEM, keyPEM := genTestCertPEM(t, []string{"a.example.com"}, nil)
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatalf("Failed to load generated certificate: %v", err)
+	}
+
+	if err := server.AddSNICertificate([]string{"override.example.com"}, cert); err != nil {
+		t.Fatalf("Expected AddSNICertificate to succeed, received error '%v'.", err)
+	}
+	if err := server.AddSNICertificate(nil, cert); err == nil {
+		t.Fatal("Expected AddSNICertificate with no hostnames to fail.")
+	}
+
+	got, err := server.getCertificateForClientHello(&tls.ClientHelloInfo{ServerName: "override.example.com"})
+	if err != nil {
+		t.Fatalf("Expected a certificate, received error '%v'.", err)
+	}
+	if !bytes.Equal(got.Certificate[0], cert.Certificate[0]) {
+		t.Fatal("Expected the certificate bound via AddSNICertificate.")
+	}
+}
+
+func TestRemoveTLSCertificate(t *testing.T) {
+	server := New()
+
+	certPEM, keyPEM := genTestCertPEM(t, []string{"a.example.com"}, nil)
+	if err := server.AddTLSCertificate(certPEM, keyPEM); err != nil {
+		t.Fatalf("Expected AddTLSCertificate to succeed, received error '%v'.", err)
+	}
+
+	if err := server.RemoveTLSCertificate("unknown.example.com"); err == nil {
+		t.Fatal("Expected RemoveTLSCertificate for an unbound hostname to fail.")
+	}
+	if err := server.RemoveTLSCertificate("a.example.com"); err != nil {
+		t.Fatalf("Expected RemoveTLSCertificate to succeed, received error '%v'.", err)
+	}
+
+	if _, err := server.getCertificateForClientHello(&tls.ClientHelloInfo{ServerName: "a.example.com"}); err == nil {
+		t.Fatal("Expected no certificates to remain after removal.")
+	}
+	if len(server.Server.TLSConfig.Certificates) != 0 {
+		t.Fatal("Expected the removed certificate to be dropped from TLSConfig.Certificates.")
+	}
+}